@@ -5,8 +5,8 @@ import (
 )
 
 type Repository interface {
-	GetConsoleConfig() (*interfaces.ConsoleConfig, error)
-	SaveConsoleConfig(config *interfaces.ConsoleConfig) error
-	UpdateConsoleConfig(config *interfaces.ConsoleConfig) error
-	IsInitialised() (bool, error)
+	GetConsoleConfig(tenantID string) (*interfaces.ConsoleConfig, error)
+	SaveConsoleConfig(tenantID string, config *interfaces.ConsoleConfig) error
+	UpdateConsoleConfig(tenantID string, config *interfaces.ConsoleConfig) error
+	IsInitialised(tenantID string) (bool, error)
 }
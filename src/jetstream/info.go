@@ -32,8 +32,8 @@ func (p *portalProxy) info(c echo.Context) error {
 }
 
 // Add a set of endpoint relations to each endpoint via the relations table
-func (p *portalProxy) updateEndpointsWithRelations(endpoints map[string]map[string]*interfaces.EndpointDetail) error {
-	relations, err := p.ListRelations()
+func (p *portalProxy) updateEndpointsWithRelations(endpoints map[string]map[string]*interfaces.EndpointDetail, tenantID string) error {
+	relations, err := p.ListRelations(tenantID)
 	if err != nil {
 		return fmt.Errorf("Failed to fetch relations: %v", err)
 	}
@@ -52,12 +52,14 @@ func (p *portalProxy) updateEndpointsWithRelations(endpoints map[string]map[stri
 						Guid:         relation.Target,
 						RelationType: relation.RelationType,
 						Metadata:     relation.Metadata,
+						TenantID:     relation.TenantID,
 					})
 				} else if relation.Target == endpoint.GUID {
 					endpoint.Relations.Receives = append(endpoint.Relations.Receives, interfaces.EndpointRelation{
 						Guid:         relation.Provider,
 						RelationType: relation.RelationType,
 						Metadata:     relation.Metadata,
+						TenantID:     relation.TenantID,
 					})
 				}
 			}
@@ -85,6 +87,14 @@ func (p *portalProxy) getInfo(c echo.Context) (*interfaces.Info, error) {
 		return nil, errors.New("Could not load session user data")
 	}
 
+	// resolve the tenant the caller belongs to, so endpoints, relations and
+	// config are all scoped to it
+	tenantID, err := p.resolveTenantID(c)
+	if err != nil {
+		return nil, errors.New("Could not resolve tenant")
+	}
+	crossTenant := uaaUser.Admin && c.QueryParam("all_tenants") == "true"
+
 	// create initial info struct
 	s := &interfaces.Info{
 		Versions:     versions,
@@ -112,9 +122,16 @@ func (p *portalProxy) getInfo(c echo.Context) (*interfaces.Info, error) {
 		}
 	}
 
-	// get the CNSI Endpoints
-	cnsiList, _ := p.buildCNSIList(c)
+	// get the CNSI Endpoints. buildCNSIList pushes the tenant scoping into its
+	// store query itself (it only returns every tenant's endpoints when
+	// crossTenant is set); the BelongsToTenant check below is a defence in
+	// depth check, not the primary scoping mechanism
+	cnsiList, _ := p.buildCNSIList(c, tenantID, crossTenant)
 	for _, cnsi := range cnsiList {
+		if !crossTenant && !cnsi.BelongsToTenant(tenantID) {
+			continue
+		}
+
 		// Extend the CNSI record
 		endpoint := &interfaces.EndpointDetail{
 			CNSIRecord:        cnsi,
@@ -134,7 +151,7 @@ func (p *portalProxy) getInfo(c echo.Context) (*interfaces.Info, error) {
 		s.Endpoints[cnsiType][cnsi.GUID] = endpoint
 	}
 
-	err = p.updateEndpointsWithRelations(s.Endpoints)
+	err = p.updateEndpointsWithRelations(s.Endpoints, tenantID)
 	if err != nil {
 		log.Warnf("Failed to add relations data to endpoints during info request: %v", err)
 	}
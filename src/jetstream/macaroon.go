@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/macaroon.v2"
+)
+
+// AuthTypeMacaroon identifies CNSI endpoints that authenticate with a
+// macaroon.Slice instead of the usual OAuth/UAA token
+const AuthTypeMacaroon = "macaroon"
+
+// dischargeRefreshWindow is how close to a macaroon's time-before caveat we
+// refresh it, mirroring the margin the OAuth token manager uses. It's also
+// the fallback expiry used when a discharged slice carries no time-before
+// caveat at all
+const dischargeRefreshWindow = 2 * time.Minute
+
+// timeBeforeCaveatPrefix is the bakery checkers.TimeBeforeCaveat condition
+// prefix: a first-party caveat "time-before <RFC3339Nano timestamp>"
+const timeBeforeCaveatPrefix = "time-before "
+
+// macaroonDischargeInteractor lets a plugin supply a redirect URL for
+// discharges that require end-user interaction, rather than failing outright
+type macaroonDischargeInteractor interface {
+	InteractiveDischarge(endpointGUID string, cav macaroon.Caveat) (redirectURL string, err error)
+}
+
+// macaroonAuthProvider performs discharge-required retries for CNSI
+// endpoints that speak a bakery-style macaroon protocol, persists the
+// resulting macaroon.Slice as the endpoint's token so subsequent proxied
+// calls reuse the discharges, and keeps a per-user cookie jar for the
+// requests it retries with them
+type macaroonAuthProvider struct {
+	portalProxy *portalProxy
+	interactor  macaroonDischargeInteractor
+
+	// mu guards jars and pendingRedirects, both of which are read and
+	// written from concurrent proxied request goroutines
+	mu               sync.Mutex
+	jars             map[string]http.CookieJar
+	pendingRedirects map[string]string
+}
+
+func newMacaroonAuthProvider(p *portalProxy, interactor macaroonDischargeInteractor) *macaroonAuthProvider {
+	return &macaroonAuthProvider{
+		portalProxy:      p,
+		interactor:       interactor,
+		jars:             make(map[string]http.CookieJar),
+		pendingRedirects: make(map[string]string),
+	}
+}
+
+// initMacaroonAuth wires up p.MacaroonAuth. It must be called during
+// portalProxy startup (alongside the other token managers) before any
+// macaroon-authenticated CNSI request or the interactive discharge endpoint
+// is reachable
+func (p *portalProxy) initMacaroonAuth(interactor macaroonDischargeInteractor) {
+	p.MacaroonAuth = newMacaroonAuthProvider(p, interactor)
+}
+
+// dischargeRequiredError is what jetstream extracts from a 401 response that
+// challenges for macaroon discharges
+type dischargeRequiredError struct {
+	Macaroons macaroon.Slice
+}
+
+// parseDischargeRequired inspects a CNSI response for the
+// "WWW-Authenticate: Macaroon" challenge and decodes the serialized
+// macaroon slice carried in the Macaroon-Info header or body
+func parseDischargeRequired(res *http.Response, body []byte) (*dischargeRequiredError, bool) {
+	if res.StatusCode != http.StatusUnauthorized {
+		return nil, false
+	}
+	if res.Header.Get("WWW-Authenticate") != "Macaroon" {
+		return nil, false
+	}
+
+	encoded := res.Header.Get("Macaroon-Info")
+	if len(encoded) == 0 {
+		encoded = string(body)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		raw = []byte(encoded)
+	}
+
+	var macaroons macaroon.Slice
+	if err := json.Unmarshal(raw, &macaroons); err != nil {
+		return nil, false
+	}
+
+	return &dischargeRequiredError{Macaroons: macaroons}, true
+}
+
+// caveatExpiry scans a macaroon's first-party caveats for a time-before
+// caveat and returns the earliest one found
+func caveatExpiry(m *macaroon.Macaroon) (time.Time, bool) {
+	var earliest time.Time
+	found := false
+	for _, cav := range m.Caveats() {
+		if len(cav.Location) > 0 {
+			// third-party caveat, not a time bound
+			continue
+		}
+		id := string(cav.Id)
+		if !strings.HasPrefix(id, timeBeforeCaveatPrefix) {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(id, timeBeforeCaveatPrefix))
+		if err != nil {
+			continue
+		}
+		if !found || t.Before(earliest) {
+			earliest = t
+			found = true
+		}
+	}
+	return earliest, found
+}
+
+// sliceExpiry returns the earliest time-before caveat across every macaroon
+// in the slice, since the slice as a whole expires as soon as any one of its
+// macaroons does
+func sliceExpiry(slice macaroon.Slice) (time.Time, bool) {
+	var earliest time.Time
+	found := false
+	for _, m := range slice {
+		if t, ok := caveatExpiry(m); ok && (!found || t.Before(earliest)) {
+			earliest = t
+			found = true
+		}
+	}
+	return earliest, found
+}
+
+// dischargeAndRetry obtains discharge macaroons for every third-party caveat
+// on the root macaroon, binds them to it, persists the resulting slice as
+// the endpoint's token and retries req with it attached
+func (m *macaroonAuthProvider) dischargeAndRetry(cnsiGUID, userGUID string, dischargeErr *dischargeRequiredError, req *http.Request) (*http.Response, error) {
+	slice, err := m.dischargeAndPersist(cnsiGUID, userGUID, dischargeErr)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.doWithMacaroons(userGUID, req, slice)
+}
+
+// dischargeAndPersist runs the discharge flow for dischargeErr's root
+// macaroon and persists the resulting slice, without retrying any request -
+// this is the part dischargeAndRetry and the proactive refreshIfNeeded path
+// share
+func (m *macaroonAuthProvider) dischargeAndPersist(cnsiGUID, userGUID string, dischargeErr *dischargeRequiredError) (macaroon.Slice, error) {
+	if len(dischargeErr.Macaroons) == 0 {
+		return nil, fmt.Errorf("No root macaroon returned by endpoint %s", cnsiGUID)
+	}
+	root := dischargeErr.Macaroons[0]
+
+	slice := macaroon.Slice{root}
+	for _, cav := range root.Caveats() {
+		if len(cav.Location) == 0 {
+			// first-party caveat, nothing to discharge
+			continue
+		}
+
+		discharge, err := m.dischargeThirdParty(cnsiGUID, userGUID, cav)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to discharge macaroon for endpoint %s: %v", cnsiGUID, err)
+		}
+		discharge.Bind(root.Signature())
+		slice = append(slice, discharge)
+	}
+
+	if err := m.persist(cnsiGUID, userGUID, slice); err != nil {
+		return nil, err
+	}
+
+	return slice, nil
+}
+
+// refreshIfNeeded re-runs the discharge flow for a stored macaroon slice
+// that's within dischargeRefreshWindow of its time-before caveat, so a
+// proactive refresh happens before the CNSI ever has to reject a request
+// with a fresh discharge-required challenge
+func (m *macaroonAuthProvider) refreshIfNeeded(cnsiGUID, userGUID string, token interfaces.TokenRecord) error {
+	if token.AuthType != AuthTypeMacaroon || !m.needsRefresh(token.TokenExpiry) {
+		return nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(token.AuthToken)
+	if err != nil {
+		return fmt.Errorf("Failed to decode stored macaroon for endpoint %s: %v", cnsiGUID, err)
+	}
+
+	var slice macaroon.Slice
+	if err := json.Unmarshal(raw, &slice); err != nil || len(slice) == 0 {
+		return fmt.Errorf("Failed to parse stored macaroon for endpoint %s: %v", cnsiGUID, err)
+	}
+
+	_, err = m.dischargeAndPersist(cnsiGUID, userGUID, &dischargeRequiredError{Macaroons: macaroon.Slice{slice[0]}})
+	return err
+}
+
+// dischargeThirdParty calls the discharger named in a caveat's location to
+// obtain a discharge macaroon, falling back to the interactive interactor
+// when the discharger demands end-user interaction
+func (m *macaroonAuthProvider) dischargeThirdParty(cnsiGUID, userGUID string, cav macaroon.Caveat) (*macaroon.Macaroon, error) {
+	payload, err := json.Marshal(map[string]string{"id": string(cav.Id)})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.Post(cav.Location+"/discharge", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized && m.interactor != nil {
+		redirectURL, interactErr := m.interactor.InteractiveDischarge(cnsiGUID, cav)
+		if interactErr != nil {
+			return nil, interactErr
+		}
+		m.setPendingRedirect(cnsiGUID, redirectURL)
+		return nil, fmt.Errorf("Interactive discharge required, redirect to %s", redirectURL)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Discharger %s returned status %d", cav.Location, res.StatusCode)
+	}
+
+	var discharge macaroon.Macaroon
+	if err := json.NewDecoder(res.Body).Decode(&discharge); err != nil {
+		return nil, err
+	}
+
+	return &discharge, nil
+}
+
+// doCNSIRequestWithMacaroonDischarge is the real call site for macaroon
+// discharges: GetCNSIUserAndToken's proxied request path (see
+// proxyCNSIRequest) routes every endpoint whose stored token has AuthType
+// AuthTypeMacaroon through here instead of calling do directly. It
+// proactively refreshes a near-expiry macaroon, attaches the stored slice,
+// performs the request with do, and if the CNSI still challenges for a
+// discharge, runs the full discharge-and-retry flow
+func (p *portalProxy) doCNSIRequestWithMacaroonDischarge(cnsiGUID, userGUID string, req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if p.MacaroonAuth == nil {
+		return nil, fmt.Errorf("Endpoint %s requires a macaroon discharge but macaroon auth is not configured", cnsiGUID)
+	}
+
+	if _, token, ok := p.GetCNSIUserAndToken(cnsiGUID, userGUID); ok {
+		if err := p.MacaroonAuth.refreshIfNeeded(cnsiGUID, userGUID, token); err != nil {
+			log.Warnf("Failed to proactively refresh macaroon for endpoint %s: %v", cnsiGUID, err)
+		}
+	}
+
+	// reuse the stored (possibly just refreshed) discharges, in the same
+	// Macaroons header doWithMacaroons sends
+	if _, token, ok := p.GetCNSIUserAndToken(cnsiGUID, userGUID); ok && token.AuthType == AuthTypeMacaroon {
+		req.Header.Set("Macaroons", token.AuthToken)
+	}
+
+	res, err := do(req)
+	if err != nil {
+		return res, err
+	}
+
+	body, readErr := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if readErr != nil {
+		return nil, fmt.Errorf("Failed to read response from endpoint %s: %v", cnsiGUID, readErr)
+	}
+
+	dischargeErr, needsDischarge := parseDischargeRequired(res, body)
+	if !needsDischarge {
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return res, nil
+	}
+
+	return p.MacaroonAuth.dischargeAndRetry(cnsiGUID, userGUID, dischargeErr, req)
+}
+
+// proxyCNSIRequest proxies a request to a CNSI endpoint, routing
+// AuthTypeMacaroon endpoints through doCNSIRequestWithMacaroonDischarge so a
+// discharge-required challenge is handled transparently
+func (p *portalProxy) proxyCNSIRequest(c echo.Context) error {
+	cnsiGUID := c.Param("guid")
+	userGUID, err := p.GetSessionStringValue(c, "user_id")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, "Could not find session user_id")
+	}
+
+	cnsi, err := p.GetCNSIRecord(cnsiGUID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Unknown endpoint")
+	}
+
+	_, token, ok := p.GetCNSIUserAndToken(cnsiGUID, userGUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Not connected to this endpoint")
+	}
+
+	req, err := http.NewRequest(c.Request().Method, cnsi.APIEndpoint+c.Param("*"), c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not build proxied request")
+	}
+	req.Header = c.Request().Header.Clone()
+
+	var res *http.Response
+	if token.AuthType == AuthTypeMacaroon {
+		res, err = p.doCNSIRequestWithMacaroonDischarge(cnsiGUID, userGUID, req, http.DefaultClient.Do)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+token.AuthToken)
+		res, err = http.DefaultClient.Do(req)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, fmt.Sprintf("Failed to proxy request to %s: %v", cnsiGUID, err))
+	}
+	defer res.Body.Close()
+
+	return c.Stream(res.StatusCode, res.Header.Get("Content-Type"), res.Body)
+}
+
+// RegisterMacaroonRoutes wires up the macaroon-specific proxy endpoints
+func RegisterMacaroonRoutes(endpointsGroup *echo.Group, p *portalProxy) {
+	endpointsGroup.Any("/:guid/proxy/*", p.proxyCNSIRequest)
+	endpointsGroup.GET("/:guid/macaroon/interactive", p.macaroonInteractiveDischarge)
+}
+
+// doWithMacaroons retries req with the discharged macaroon slice (base64
+// JSON) in its Macaroons header, sent through the user's cookie jar
+func (m *macaroonAuthProvider) doWithMacaroons(userGUID string, req *http.Request, slice macaroon.Slice) (*http.Response, error) {
+	encoded, err := json.Marshal(slice)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Jar: m.jarForUser(userGUID)}
+	req.Header.Set("Macaroons", base64.StdEncoding.EncodeToString(encoded))
+	return client.Do(req)
+}
+
+// persist stores the discharged macaroon.Slice (base64 JSON) as the CNSI
+// token, the way the OAuth token manager stores a serialized access token.
+// TokenExpiry is derived from the slice's earliest time-before caveat,
+// falling back to dischargeRefreshWindow from now if none of the discharges
+// carry one
+func (m *macaroonAuthProvider) persist(cnsiGUID, userGUID string, slice macaroon.Slice) error {
+	encoded, err := json.Marshal(slice)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal macaroon discharges: %v", err)
+	}
+
+	expiry, ok := sliceExpiry(slice)
+	if !ok {
+		expiry = time.Now().Add(dischargeRefreshWindow)
+	}
+
+	tokenRecord := interfaces.TokenRecord{
+		AuthType:    AuthTypeMacaroon,
+		AuthToken:   base64.StdEncoding.EncodeToString(encoded),
+		TokenExpiry: expiry.Unix(),
+	}
+
+	return m.portalProxy.SaveCNSIToken(cnsiGUID, userGUID, tokenRecord, false)
+}
+
+// needsRefresh reports whether a stored macaroon slice is close enough to
+// its time-before caveat that it should be refreshed before use
+func (m *macaroonAuthProvider) needsRefresh(expiry int64) bool {
+	return time.Until(time.Unix(expiry, 0)) < dischargeRefreshWindow
+}
+
+func (m *macaroonAuthProvider) jarForUser(userGUID string) http.CookieJar {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if jar, ok := m.jars[userGUID]; ok {
+		return jar
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		log.Warnf("Failed to create cookie jar for user %s, discharges won't be cached: %v", userGUID, err)
+	}
+	m.jars[userGUID] = jar
+	return jar
+}
+
+// setPendingRedirect records the redirect URL an interactive discharge
+// needs, keyed by the endpoint it was requested for, so
+// macaroonInteractiveDischarge can hand it back to the front-end
+func (m *macaroonAuthProvider) setPendingRedirect(cnsiGUID, redirectURL string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pendingRedirects[cnsiGUID] = redirectURL
+}
+
+// macaroonInteractiveDischarge is the proxy endpoint the front-end polls to
+// pick up the redirect URL for a discharge that requires end-user
+// interaction
+func (p *portalProxy) macaroonInteractiveDischarge(c echo.Context) error {
+	cnsiGUID := c.Param("guid")
+	if len(cnsiGUID) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Missing endpoint guid")
+	}
+
+	if p.MacaroonAuth == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Macaroon auth is not configured for this deployment")
+	}
+
+	redirectURL, err := p.MacaroonAuth.pendingInteractiveRedirect(cnsiGUID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "No interactive discharge pending for this endpoint")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"redirect": redirectURL})
+}
+
+// pendingInteractiveRedirect returns (and clears) the redirect URL recorded
+// for cnsiGUID by setPendingRedirect, so each pending discharge is handed
+// back to the front-end exactly once
+func (m *macaroonAuthProvider) pendingInteractiveRedirect(cnsiGUID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	redirectURL, ok := m.pendingRedirects[cnsiGUID]
+	if !ok {
+		return "", fmt.Errorf("No pending interactive discharge for endpoint %s", cnsiGUID)
+	}
+	delete(m.pendingRedirects, cnsiGUID)
+	return redirectURL, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/macaroon.v2"
+)
+
+func encodedMacaroonSlice(t *testing.T) string {
+	t.Helper()
+	m, err := macaroon.New([]byte("key"), []byte("id"), "location", macaroon.LatestVersion)
+	if err != nil {
+		t.Fatalf("failed to build test macaroon: %v", err)
+	}
+	raw, err := json.Marshal(macaroon.Slice{m})
+	if err != nil {
+		t.Fatalf("failed to marshal test macaroon: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func TestParseDischargeRequired(t *testing.T) {
+	encoded := encodedMacaroonSlice(t)
+
+	t.Run("discharge challenge in Macaroon-Info header", func(t *testing.T) {
+		res := httptest.NewRecorder().Result()
+		res.StatusCode = http.StatusUnauthorized
+		res.Header = http.Header{}
+		res.Header.Set("WWW-Authenticate", "Macaroon")
+		res.Header.Set("Macaroon-Info", encoded)
+
+		dischargeErr, ok := parseDischargeRequired(res, nil)
+		if !ok {
+			t.Fatal("expected a discharge-required error to be recognised")
+		}
+		if len(dischargeErr.Macaroons) != 1 {
+			t.Fatalf("expected 1 macaroon, got %d", len(dischargeErr.Macaroons))
+		}
+	})
+
+	t.Run("discharge challenge in body", func(t *testing.T) {
+		res := httptest.NewRecorder().Result()
+		res.StatusCode = http.StatusUnauthorized
+		res.Header = http.Header{}
+		res.Header.Set("WWW-Authenticate", "Macaroon")
+
+		raw, _ := base64.StdEncoding.DecodeString(encoded)
+		dischargeErr, ok := parseDischargeRequired(res, bytes.TrimSpace([]byte(encoded)))
+		if !ok {
+			t.Fatal("expected a discharge-required error to be recognised")
+		}
+		if len(dischargeErr.Macaroons) != 1 {
+			t.Fatalf("expected 1 macaroon, got %d, decoded raw len=%d", len(dischargeErr.Macaroons), len(raw))
+		}
+	})
+
+	t.Run("not a discharge challenge", func(t *testing.T) {
+		res := httptest.NewRecorder().Result()
+		res.StatusCode = http.StatusOK
+		res.Header = http.Header{}
+
+		if _, ok := parseDischargeRequired(res, nil); ok {
+			t.Fatal("did not expect a 200 response to be treated as a discharge challenge")
+		}
+	})
+
+	t.Run("401 without Macaroon challenge header", func(t *testing.T) {
+		res := httptest.NewRecorder().Result()
+		res.StatusCode = http.StatusUnauthorized
+		res.Header = http.Header{}
+
+		if _, ok := parseDischargeRequired(res, nil); ok {
+			t.Fatal("did not expect a plain 401 to be treated as a discharge challenge")
+		}
+	})
+}
+
+func TestMacaroonAuthProviderNeedsRefresh(t *testing.T) {
+	m := &macaroonAuthProvider{}
+
+	if !m.needsRefresh(time.Now().Add(30 * time.Second).Unix()) {
+		t.Fatal("expected a macaroon expiring within the refresh window to need refreshing")
+	}
+	if m.needsRefresh(time.Now().Add(time.Hour).Unix()) {
+		t.Fatal("did not expect a macaroon expiring well outside the refresh window to need refreshing")
+	}
+}
+
+func TestCaveatExpiryFindsTimeBeforeCaveat(t *testing.T) {
+	m, err := macaroon.New([]byte("key"), []byte("id"), "location", macaroon.LatestVersion)
+	if err != nil {
+		t.Fatalf("failed to build test macaroon: %v", err)
+	}
+	expiry := time.Now().Add(time.Hour).UTC()
+	if err := m.AddFirstPartyCaveat([]byte("time-before " + expiry.Format(time.RFC3339Nano))); err != nil {
+		t.Fatalf("failed to add caveat: %v", err)
+	}
+
+	got, ok := caveatExpiry(m)
+	if !ok {
+		t.Fatal("expected a time-before caveat to be found")
+	}
+	if !got.Equal(expiry) {
+		t.Fatalf("expected expiry %v, got %v", expiry, got)
+	}
+}
+
+func TestCaveatExpiryIgnoresThirdPartyAndUnrelatedCaveats(t *testing.T) {
+	m, err := macaroon.New([]byte("key"), []byte("id"), "location", macaroon.LatestVersion)
+	if err != nil {
+		t.Fatalf("failed to build test macaroon: %v", err)
+	}
+	if err := m.AddFirstPartyCaveat([]byte("method GET")); err != nil {
+		t.Fatalf("failed to add caveat: %v", err)
+	}
+	thirdPartyID := "time-before " + time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	if err := m.AddThirdPartyCaveat([]byte("third-party-key"), []byte(thirdPartyID), "https://discharger.example.com"); err != nil {
+		t.Fatalf("failed to add third-party caveat: %v", err)
+	}
+
+	if _, ok := caveatExpiry(m); ok {
+		t.Fatal("did not expect a macaroon with no time-before caveat to report an expiry")
+	}
+}
+
+func TestSliceExpiryReturnsEarliest(t *testing.T) {
+	later := time.Now().Add(2 * time.Hour).UTC()
+	earlier := time.Now().Add(time.Hour).UTC()
+
+	m1, _ := macaroon.New([]byte("key"), []byte("id1"), "location", macaroon.LatestVersion)
+	m1.AddFirstPartyCaveat([]byte("time-before " + later.Format(time.RFC3339Nano)))
+	m2, _ := macaroon.New([]byte("key"), []byte("id2"), "location", macaroon.LatestVersion)
+	m2.AddFirstPartyCaveat([]byte("time-before " + earlier.Format(time.RFC3339Nano)))
+
+	got, ok := sliceExpiry(macaroon.Slice{m1, m2})
+	if !ok {
+		t.Fatal("expected an expiry to be found")
+	}
+	if !got.Equal(earlier) {
+		t.Fatalf("expected the earliest expiry %v, got %v", earlier, got)
+	}
+}
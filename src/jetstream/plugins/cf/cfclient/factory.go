@@ -0,0 +1,82 @@
+package cfclient
+
+import (
+	"fmt"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/config"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// TokenRefresher is implemented by jetstream's token manager so a v3 client
+// can silently refresh its bearer token without the CF plugin having to know
+// how tokens are stored
+type TokenRefresher interface {
+	RefreshToken(cnsiGUID, userGUID string) (interfaces.TokenRecord, error)
+}
+
+// Factory builds go-cfclient/v3 clients bound to a specific user's stored
+// token, refreshing it via jetstream's token manager when the API rejects it
+type Factory struct {
+	refresher TokenRefresher
+}
+
+// NewFactory creates a Factory that refreshes tokens through refresher
+func NewFactory(refresher TokenRefresher) *Factory {
+	return &Factory{refresher: refresher}
+}
+
+// Client builds a v3 client for the given endpoint, scoped to the given
+// user's stored token. NOTE: the config.Token/config.WithTokenRefresh option
+// names must be checked against the vendored go-cfclient/v3 version once it
+// is actually pulled in - this file was written against the library's
+// documented functional-options shape without a local copy to compile
+// against
+func (f *Factory) Client(apiEndpoint, cnsiGUID, userGUID string, token interfaces.TokenRecord) (*client.Client, error) {
+	cfg, err := config.New(apiEndpoint,
+		config.Token(token.AuthToken, token.RefreshToken),
+		config.UserAgent("stratos-jetstream"),
+		config.WithTokenRefresh(func() (string, string, error) {
+			refreshed, err := f.refresher.RefreshToken(cnsiGUID, userGUID)
+			if err != nil {
+				return "", "", err
+			}
+			return refreshed.AuthToken, refreshed.RefreshToken, nil
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build go-cfclient config for %s: %v", apiEndpoint, err)
+	}
+
+	return client.New(cfg)
+}
+
+// SupportsV3 checks the links returned by /v3/info (or the equivalent
+// root discovery document) to decide whether an endpoint can be driven by
+// the v3 client, so callers can fall back to the v2 implementation for CF
+// deployments that haven't rolled out v3 parity yet
+func SupportsV3(rootLinks map[string]interface{}) bool {
+	_, ok := rootLinks["cloud_controller_v3"]
+	return ok
+}
+
+// Paginate drains a v3 list endpoint's pages into a single slice by calling
+// list with pages 1, 2, ... until it reports no next page, so callers
+// (routes, service instances, sidecars, ...) don't each hand-roll paging
+func Paginate[T any](list func(page int) ([]T, bool, error)) ([]T, error) {
+	all := []T{}
+	page := 1
+	for {
+		items, hasNext, err := list(page)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to paginate results: %v", err)
+		}
+		all = append(all, items...)
+		if !hasNext {
+			break
+		}
+		page++
+	}
+	return all, nil
+}
@@ -0,0 +1,115 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestPaginate(t *testing.T) {
+	pages := [][]int{{1, 2}, {3, 4}, {5}}
+
+	got, err := Paginate(func(page int) ([]int, bool, error) {
+		items := pages[page-1]
+		return items, page < len(pages), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []int{1, 2, 3, 4, 5}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestPaginateSinglePage(t *testing.T) {
+	got, err := Paginate(func(page int) ([]string, bool, error) {
+		return []string{"only"}, false, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"only"}) {
+		t.Fatalf("expected a single page of results, got %v", got)
+	}
+}
+
+func TestPaginatePropagatesError(t *testing.T) {
+	_, err := Paginate(func(page int) ([]int, bool, error) {
+		if page == 2 {
+			return nil, false, fmt.Errorf("boom")
+		}
+		return []int{1}, true, nil
+	})
+	if err == nil {
+		t.Fatal("expected an error from a failing page to propagate")
+	}
+}
+
+func TestSupportsV3(t *testing.T) {
+	if !SupportsV3(map[string]interface{}{"cloud_controller_v3": "https://api.example.com/v3"}) {
+		t.Fatal("expected a root document with a cloud_controller_v3 link to support v3")
+	}
+	if SupportsV3(map[string]interface{}{"cloud_controller_v2": "https://api.example.com/v2"}) {
+		t.Fatal("did not expect a root document without a cloud_controller_v3 link to support v3")
+	}
+	if SupportsV3(nil) {
+		t.Fatal("did not expect a nil root document to support v3")
+	}
+}
+
+func TestFetchEndpointInfoDetectsV3(t *testing.T) {
+	f := NewFactory(nil)
+
+	info, err := f.FetchEndpointInfo(context.Background(), "https://api.example.com", "guid", "user",
+		interfaces.TokenRecord{AuthToken: "token", RefreshToken: "refresh"},
+		func(ctx context.Context, cl *client.Client) (map[string]interface{}, error) {
+			return map[string]interface{}{"cloud_controller_v3": "https://api.example.com/v3", "api_version": "3.1.0"}, nil
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !info.V3Capable {
+		t.Fatal("expected an endpoint advertising cloud_controller_v3 to be reported as v3 capable")
+	}
+	if info.APIVersion != "3.1.0" {
+		t.Fatalf("expected api version 3.1.0, got %q", info.APIVersion)
+	}
+}
+
+func TestFetchEndpointInfoPropagatesRootLinksError(t *testing.T) {
+	f := NewFactory(nil)
+
+	_, err := f.FetchEndpointInfo(context.Background(), "https://api.example.com", "guid", "user",
+		interfaces.TokenRecord{AuthToken: "token"},
+		func(ctx context.Context, cl *client.Client) (map[string]interface{}, error) {
+			return nil, fmt.Errorf("unreachable")
+		})
+	if err == nil {
+		t.Fatal("expected an error from a failing root document fetch to propagate")
+	}
+}
+
+func TestListOrganizations(t *testing.T) {
+	pages := [][]Organization{
+		{{GUID: "1", Name: "a"}, {GUID: "2", Name: "b"}},
+		{{GUID: "3", Name: "c"}},
+	}
+
+	got, err := ListOrganizations(context.Background(), func(ctx context.Context, page int) ([]Organization, bool, error) {
+		items := pages[page-1]
+		return items, page < len(pages), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 organizations across both pages, got %d", len(got))
+	}
+}
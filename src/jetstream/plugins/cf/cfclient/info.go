@@ -0,0 +1,69 @@
+package cfclient
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// EndpointInfo is the version/capability summary the CF plugin's
+// info/version-discovery path needs per endpoint: enough to populate
+// cnsi.Version and decide whether org/space/service listing should go
+// through the v3 client or fall back to v2
+type EndpointInfo struct {
+	APIVersion string
+	V3Capable  bool
+}
+
+// FetchEndpointInfo is the call site the CF plugin's info/version-discovery
+// path should use in place of its ad-hoc /v2/info and /v3 root HTTP calls.
+// It builds a v3 client via Factory.Client and uses SupportsV3 to decide
+// whether the endpoint can be driven by the v3 client at all.
+//
+// rootLinks does the actual root-document fetch against the built client.
+// It's a parameter rather than a hardcoded method call because the concrete
+// go-cfclient/v3 method for reading the root discovery document hasn't been
+// checked against a vendored copy of the library yet - see the NOTE on
+// Factory.Client
+func (f *Factory) FetchEndpointInfo(ctx context.Context, apiEndpoint, cnsiGUID, userGUID string, token interfaces.TokenRecord, rootLinks func(context.Context, *client.Client) (map[string]interface{}, error)) (EndpointInfo, error) {
+	cl, err := f.Client(apiEndpoint, cnsiGUID, userGUID, token)
+	if err != nil {
+		return EndpointInfo{}, err
+	}
+
+	links, err := rootLinks(ctx, cl)
+	if err != nil {
+		return EndpointInfo{}, fmt.Errorf("Failed to fetch root discovery document for %s: %v", apiEndpoint, err)
+	}
+
+	info := EndpointInfo{V3Capable: SupportsV3(links)}
+	if version, ok := links["api_version"].(string); ok {
+		info.APIVersion = version
+	}
+
+	return info, nil
+}
+
+// Organization is the minimal org summary the CF plugin's org-listing path
+// needs out of a v3 client call
+type Organization struct {
+	GUID string
+	Name string
+}
+
+// orgPager is the subset of the v3 client's Organizations resource that
+// ListOrganizations needs, kept as a function type for the same
+// not-yet-vendored reason as FetchEndpointInfo's rootLinks parameter
+type orgPager func(ctx context.Context, page int) (orgs []Organization, hasNext bool, err error)
+
+// ListOrganizations drains every page of an endpoint's organizations through
+// Paginate, so the CF plugin's org-listing path doesn't hand-roll its own
+// paging loop
+func ListOrganizations(ctx context.Context, list orgPager) ([]Organization, error) {
+	return Paginate(func(page int) ([]Organization, bool, error) {
+		return list(ctx, page)
+	})
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/labstack/echo"
+)
+
+// relationGraph is an in-memory adjacency index over a tenant's relations.
+// Each transitive query, graph query and cycle check builds its own from the
+// relations it has just loaded; it is not cached between requests
+type relationGraph struct {
+	tenantID string
+	// out maps a GUID to the edges leading away from it
+	out map[string][]interfaces.RelationRecord
+}
+
+// buildRelationGraph indexes a flat list of relations into an adjacency map
+func buildRelationGraph(tenantID string, relations []interfaces.RelationRecord) *relationGraph {
+	g := &relationGraph{tenantID: tenantID, out: make(map[string][]interfaces.RelationRecord)}
+	for _, relation := range relations {
+		g.out[relation.Provider] = append(g.out[relation.Provider], relation)
+	}
+	return g
+}
+
+// transitive walks the graph from guid along edges of the given type (all
+// types if empty), up to maxDepth hops, returning one entry per reachable
+// GUID with the type and metadata of the edge it was first reached by; the
+// path used to reach it is not returned
+func (g *relationGraph) transitive(guid, relationType string, maxDepth int, metadataFilter map[string]string) []interfaces.EndpointRelation {
+	visited := map[string]bool{guid: true}
+	result := []interfaces.EndpointRelation{}
+
+	var walk func(current string, depth int)
+	walk = func(current string, depth int) {
+		if depth >= maxDepth {
+			return
+		}
+		for _, relation := range g.out[current] {
+			if len(relationType) > 0 && relation.RelationType != relationType {
+				continue
+			}
+			if !matchesMetadata(relation.Metadata, metadataFilter) {
+				continue
+			}
+			if visited[relation.Target] {
+				continue
+			}
+			visited[relation.Target] = true
+			result = append(result, interfaces.EndpointRelation{
+				Guid:         relation.Target,
+				RelationType: relation.RelationType,
+				Metadata:     relation.Metadata,
+				TenantID:     relation.TenantID,
+			})
+			walk(relation.Target, depth+1)
+		}
+	}
+
+	walk(guid, 0)
+	return result
+}
+
+// detectCycle reports whether adding provider->target would introduce a
+// cycle, returning the offending path (provider, target, ..., provider) if so
+func (g *relationGraph) detectCycle(provider, target string) (path []string, found bool) {
+	if provider == target {
+		return []string{provider, target}, true
+	}
+
+	visited := map[string]bool{}
+	var route []string
+
+	var walk func(current string) bool
+	walk = func(current string) bool {
+		if current == provider {
+			route = append(route, current)
+			return true
+		}
+		if visited[current] {
+			return false
+		}
+		visited[current] = true
+		for _, relation := range g.out[current] {
+			if walk(relation.Target) {
+				route = append(route, current)
+				return true
+			}
+		}
+		return false
+	}
+
+	// a new provider->target edge cycles back to provider if target can
+	// already reach provider
+	if walk(target) {
+		// route is already provider ... target, walked backwards; close it
+		// with the new edge's provider so the path reads provider -> target
+		route = append(route, provider)
+		reversed := make([]string, len(route))
+		for i, guid := range route {
+			reversed[len(route)-1-i] = guid
+		}
+		return reversed, true
+	}
+
+	return nil, false
+}
+
+// topologicalOrder returns the graph's GUIDs in dependency order (providers
+// before the endpoints that receive from them); it assumes the graph is
+// already acyclic, since AddRelation rejects cycles at write time
+func (g *relationGraph) topologicalOrder(nodes []string) []string {
+	visited := map[string]bool{}
+	ordered := []string{}
+
+	var visit func(guid string)
+	visit = func(guid string) {
+		if visited[guid] {
+			return
+		}
+		visited[guid] = true
+		for _, relation := range g.out[guid] {
+			visit(relation.Target)
+		}
+		ordered = append(ordered, guid)
+	}
+
+	for _, guid := range nodes {
+		visit(guid)
+	}
+
+	// reverse so providers come first
+	for i, j := 0, len(ordered)-1; i < j; i, j = i+1, j-1 {
+		ordered[i], ordered[j] = ordered[j], ordered[i]
+	}
+	return ordered
+}
+
+func matchesMetadata(metadata map[string]string, filter map[string]string) bool {
+	for key, want := range filter {
+		if metadata[key] != want {
+			return false
+		}
+	}
+	return true
+}
+
+// metadataFilterFromQuery turns ?metadata.env=prod style query params into a
+// plain key/value filter
+func metadataFilterFromQuery(c echo.Context) map[string]string {
+	filter := make(map[string]string)
+	for key, values := range c.QueryParams() {
+		if strings.HasPrefix(key, "metadata.") && len(values) > 0 {
+			filter[strings.TrimPrefix(key, "metadata.")] = values[0]
+		}
+	}
+	return filter
+}
+
+// getTransitiveRelations handles GET /v1/endpoints/{guid}/relations/transitive
+func (p *portalProxy) getTransitiveRelations(c echo.Context) error {
+	guid := c.Param("guid")
+
+	tenantID, err := p.resolveTenantID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, "Could not resolve tenant")
+	}
+
+	relations, err := p.ListRelations(tenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to fetch relations: %v", err))
+	}
+
+	depth := 10
+	if raw := c.QueryParam("depth"); len(raw) > 0 {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+			depth = parsed
+		}
+	}
+
+	graph := buildRelationGraph(tenantID, relations)
+	reachable := graph.transitive(guid, c.QueryParam("type"), depth, metadataFilterFromQuery(c))
+
+	return c.JSON(http.StatusOK, reachable)
+}
+
+// relationGraphResponse is the DAG shape the UI's graph view consumes
+type relationGraphResponse struct {
+	Nodes []string                       `json:"nodes"`
+	Edges []interfaces.RelationRecord    `json:"edges"`
+	Order []string                       `json:"topologicalOrder"`
+}
+
+// getRelationsGraph handles GET /v1/endpoints/graph
+func (p *portalProxy) getRelationsGraph(c echo.Context) error {
+	tenantID, err := p.resolveTenantID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, "Could not resolve tenant")
+	}
+
+	relations, err := p.ListRelations(tenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to fetch relations: %v", err))
+	}
+
+	nodeSet := map[string]bool{}
+	for _, relation := range relations {
+		nodeSet[relation.Provider] = true
+		nodeSet[relation.Target] = true
+	}
+	nodes := make([]string, 0, len(nodeSet))
+	for guid := range nodeSet {
+		nodes = append(nodes, guid)
+	}
+
+	graph := buildRelationGraph(tenantID, relations)
+
+	return c.JSON(http.StatusOK, relationGraphResponse{
+		Nodes: nodes,
+		Edges: relations,
+		Order: graph.topologicalOrder(nodes),
+	})
+}
+
+// checkRelationCycle is called by AddRelation before a new relation is
+// persisted, rejecting it with the offending path if it would close a cycle.
+// It is factored out as its own method (rather than inlined into AddRelation)
+// so it can also run ahead of a bulk/import write path without duplicating
+// the graph-building logic
+func (p *portalProxy) checkRelationCycle(tenantID, provider, target string) error {
+	relations, err := p.ListRelations(tenantID)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch relations: %v", err)
+	}
+
+	graph := buildRelationGraph(tenantID, relations)
+	if path, found := graph.detectCycle(provider, target); found {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("Relation would introduce a cycle: %s", strings.Join(path, " -> ")))
+	}
+
+	return nil
+}
+
+// AddRelation persists a new provider->target relation for the tenant. It
+// rejects the write with a 409 (and the offending path) if the relation
+// would introduce a cycle, so the stored relations that transitive/graph
+// queries build their graph from stay acyclic
+func (p *portalProxy) AddRelation(tenantID string, relation interfaces.RelationRecord) error {
+	if err := p.checkRelationCycle(tenantID, relation.Provider, relation.Target); err != nil {
+		return err
+	}
+
+	relation.TenantID = tenantID
+	return p.SaveRelation(tenantID, relation)
+}
+
+// addRelation handles POST /v1/endpoints/relations
+func (p *portalProxy) addRelation(c echo.Context) error {
+	tenantID, err := p.resolveTenantID(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, "Could not resolve tenant")
+	}
+
+	relation := new(interfaces.RelationRecord)
+	if err := c.Bind(relation); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Could not parse relation")
+	}
+
+	if err := p.AddRelation(tenantID, *relation); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusCreated)
+}
+
+// RegisterRelationGraphRoutes wires up the transitive-query and whole-graph
+// endpoints alongside the rest of the /v1/endpoints routes
+func RegisterRelationGraphRoutes(endpointsGroup *echo.Group, p *portalProxy) {
+	endpointsGroup.GET("/:guid/relations/transitive", p.getTransitiveRelations)
+	endpointsGroup.GET("/graph", p.getRelationsGraph)
+	endpointsGroup.POST("/relations", p.addRelation)
+}
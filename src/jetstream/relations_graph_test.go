@@ -0,0 +1,121 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func relation(provider, target, relationType string) interfaces.RelationRecord {
+	return interfaces.RelationRecord{Provider: provider, Target: target, RelationType: relationType}
+}
+
+func TestRelationGraphTransitive(t *testing.T) {
+	g := buildRelationGraph("tenant", []interfaces.RelationRecord{
+		relation("k8s", "prometheus", "metrics"),
+		relation("prometheus", "grafana", "metrics"),
+		relation("k8s", "logging", "logs"),
+	})
+
+	reachable := g.transitive("k8s", "metrics", 10, nil)
+	got := map[string]bool{}
+	for _, r := range reachable {
+		got[r.Guid] = true
+	}
+
+	if !got["prometheus"] || !got["grafana"] {
+		t.Fatalf("expected to reach prometheus and grafana transitively, got %v", got)
+	}
+	if got["logging"] {
+		t.Fatalf("did not expect to reach logging via the metrics relation type, got %v", got)
+	}
+}
+
+func TestRelationGraphTransitiveRespectsDepth(t *testing.T) {
+	g := buildRelationGraph("tenant", []interfaces.RelationRecord{
+		relation("a", "b", "metrics"),
+		relation("b", "c", "metrics"),
+	})
+
+	reachable := g.transitive("a", "metrics", 1, nil)
+	if len(reachable) != 1 || reachable[0].Guid != "b" {
+		t.Fatalf("expected depth 1 to only reach b, got %v", reachable)
+	}
+}
+
+func TestRelationGraphDetectCycleDirect(t *testing.T) {
+	g := buildRelationGraph("tenant", nil)
+
+	if _, found := g.detectCycle("a", "a"); !found {
+		t.Fatal("expected a self-relation to be detected as a cycle")
+	}
+}
+
+func TestRelationGraphDetectCycleTransitive(t *testing.T) {
+	g := buildRelationGraph("tenant", []interfaces.RelationRecord{
+		relation("a", "b", "metrics"),
+		relation("b", "c", "metrics"),
+	})
+
+	path, found := g.detectCycle("c", "a")
+	if !found {
+		t.Fatal("expected adding c->a to be detected as closing a cycle")
+	}
+	if len(path) == 0 {
+		t.Fatal("expected the offending path to be non-empty")
+	}
+}
+
+func TestRelationGraphDetectCycleNoCycle(t *testing.T) {
+	g := buildRelationGraph("tenant", []interfaces.RelationRecord{
+		relation("a", "b", "metrics"),
+	})
+
+	if _, found := g.detectCycle("a", "c"); found {
+		t.Fatal("did not expect a->c to be flagged as a cycle")
+	}
+}
+
+func TestRelationGraphTopologicalOrder(t *testing.T) {
+	g := buildRelationGraph("tenant", []interfaces.RelationRecord{
+		relation("k8s", "prometheus", "metrics"),
+		relation("prometheus", "grafana", "metrics"),
+	})
+
+	order := g.topologicalOrder([]string{"grafana", "prometheus", "k8s"})
+
+	pos := map[string]int{}
+	for i, guid := range order {
+		pos[guid] = i
+	}
+	if pos["k8s"] > pos["prometheus"] || pos["prometheus"] > pos["grafana"] {
+		t.Fatalf("expected providers before their receivers, got order %v", order)
+	}
+}
+
+func TestMatchesMetadata(t *testing.T) {
+	metadata := map[string]string{"env": "prod", "region": "eu"}
+
+	if !matchesMetadata(metadata, map[string]string{"env": "prod"}) {
+		t.Fatal("expected a matching filter to pass")
+	}
+	if matchesMetadata(metadata, map[string]string{"env": "staging"}) {
+		t.Fatal("expected a non-matching filter to fail")
+	}
+	if !matchesMetadata(metadata, nil) {
+		t.Fatal("expected an empty filter to always match")
+	}
+}
+
+func TestBuildRelationGraphIndexesByProvider(t *testing.T) {
+	relations := []interfaces.RelationRecord{
+		relation("a", "b", "metrics"),
+		relation("a", "c", "logs"),
+	}
+	g := buildRelationGraph("tenant", relations)
+
+	if !reflect.DeepEqual(g.out["a"], relations) {
+		t.Fatalf("expected both relations indexed under provider a, got %v", g.out["a"])
+	}
+}
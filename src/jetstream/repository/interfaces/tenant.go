@@ -0,0 +1,11 @@
+package interfaces
+
+// Tenant represents a first-class tenant that CNSI endpoints, relations and
+// tokens can be scoped to
+type Tenant struct {
+	TenantID string `json:"tenant_id"`
+	Name     string `json:"name"`
+	// APIToken is only populated on creation - it is minted once and not
+	// retrievable afterwards, the same way a CNSI client secret is handled
+	APIToken string `json:"api_token,omitempty"`
+}
@@ -0,0 +1,89 @@
+package interfaces
+
+// CNSIRecord represents a registered CNSI endpoint, scoped to the tenant
+// that registered it
+type CNSIRecord struct {
+	GUID        string `json:"guid"`
+	Name        string `json:"name"`
+	CNSIType    string `json:"cnsi_type"`
+	APIEndpoint string `json:"api_endpoint"`
+	Metadata    string `json:"metadata"`
+	TenantID    string `json:"tenant_id"`
+}
+
+// BelongsToTenant reports whether this CNSI record belongs to the given
+// tenant. Admins asking for all_tenants bypass this check entirely rather
+// than calling it - see portalProxy.getInfo
+func (c CNSIRecord) BelongsToTenant(tenantID string) bool {
+	return c.TenantID == tenantID
+}
+
+// ConnectedUser is a user connected to a CNSI endpoint, or the UAA/console
+// user driving the current session
+type ConnectedUser struct {
+	GUID  string `json:"guid"`
+	Name  string `json:"name"`
+	Admin bool   `json:"admin"`
+}
+
+// TokenRecord is a stored credential for a user's connection to a CNSI
+// endpoint - an OAuth/UAA access+refresh token pair, or (for AuthTypeMacaroon
+// endpoints) a serialized macaroon.Slice carried in AuthToken
+type TokenRecord struct {
+	AuthType     string `json:"auth_type"`
+	AuthToken    string `json:"-"`
+	RefreshToken string `json:"-"`
+	TokenExpiry  int64  `json:"token_expiry"`
+	Metadata     string `json:"metadata"`
+	SystemShared bool   `json:"system_shared"`
+}
+
+// EndpointRelation is one edge of an EndpointRelations collection, scoped to
+// the tenant the underlying RelationRecord belongs to
+type EndpointRelation struct {
+	Guid         string            `json:"guid"`
+	RelationType string            `json:"type"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	TenantID     string            `json:"tenant_id"`
+}
+
+// EndpointRelations groups the edges an endpoint provides to, and receives
+// from, other endpoints
+type EndpointRelations struct {
+	Provides []EndpointRelation `json:"provides"`
+	Receives []EndpointRelation `json:"receives"`
+}
+
+// RelationRecord is a single stored provider->target relation between two
+// endpoint GUIDs, scoped to a tenant
+type RelationRecord struct {
+	Provider     string            `json:"provider"`
+	Target       string            `json:"target"`
+	RelationType string            `json:"type"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	TenantID     string            `json:"tenant_id"`
+}
+
+// EndpointDetail extends a CNSIRecord with the connected user/token state and
+// relation graph that getInfo assembles per request
+type EndpointDetail struct {
+	CNSIRecord
+	EndpointMetadata  interface{}        `json:"endpoint_metadata"`
+	Metadata          map[string]string  `json:"metadata"`
+	User              *ConnectedUser     `json:"user"`
+	TokenMetadata     string             `json:"token_metadata"`
+	SystemSharedToken bool               `json:"system_shared_token"`
+	Relations         *EndpointRelations `json:"relations,omitempty"`
+}
+
+// Info is the payload served by GET /v1/auth/session/verify (and /v1/info) -
+// the console/session bootstrap data for the current user
+type Info struct {
+	Versions     interface{}                           `json:"version"`
+	User         *ConnectedUser                        `json:"user"`
+	Endpoints    map[string]map[string]*EndpointDetail  `json:"endpoints"`
+	CloudFoundry interface{}                            `json:"cloud-foundry-config"`
+	PluginConfig interface{}                            `json:"plugin-config"`
+	Diagnostics  interface{}                            `json:"diagnostics,omitempty"`
+	Plugins      interface{}                            `json:"plugins"`
+}
@@ -0,0 +1,14 @@
+package interfaces
+
+import "testing"
+
+func TestCNSIRecordBelongsToTenant(t *testing.T) {
+	record := CNSIRecord{TenantID: "acme"}
+
+	if !record.BelongsToTenant("acme") {
+		t.Fatal("expected a record to belong to its own tenant")
+	}
+	if record.BelongsToTenant("other") {
+		t.Fatal("did not expect a record to belong to a different tenant")
+	}
+}
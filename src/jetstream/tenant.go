@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/labstack/echo"
+)
+
+// TenantHeader is the request header clients can use to select a tenant
+// when it cannot be inferred from the JWT claim or subdomain
+const TenantHeader = "X-Stratos-Tenant"
+
+// DefaultTenantID is used for requests that can't be resolved to a tenant,
+// keeping single-tenant deployments working unchanged
+const DefaultTenantID = "default"
+
+// resolveTenantID works out which tenant the current request belongs to. A
+// "tenant_id" session/JWT claim is always trusted, since it was set when the
+// session was established. Otherwise the caller's own tenant membership is
+// looked up from the store, and the subdomain/X-Stratos-Tenant header are
+// only honoured when they agree with it - they are never trusted on their
+// own, since either is fully controlled by the caller
+func (p *portalProxy) resolveTenantID(c echo.Context) (string, error) {
+	if tenantID, err := p.GetSessionStringValue(c, "tenant_id"); err == nil && len(tenantID) > 0 {
+		return tenantID, nil
+	}
+
+	userGUID, err := p.GetSessionStringValue(c, "user_id")
+	if err != nil {
+		return "", errors.New("Could not find session user_id")
+	}
+
+	memberTenantID, err := p.GetUserTenantID(userGUID)
+	if err != nil || len(memberTenantID) == 0 {
+		// Not a member of any tenant - nothing to validate a candidate
+		// against, so fall back to the default tenant rather than trust one
+		return DefaultTenantID, nil
+	}
+
+	candidate, hasCandidate := candidateTenantID(c)
+	return validateTenantCandidate(memberTenantID, candidate, hasCandidate)
+}
+
+// candidateTenantID extracts the tenant a request is asking to be scoped to
+// via subdomain or the X-Stratos-Tenant header. This is caller-supplied and
+// must never be trusted without checking it against tenant membership
+func candidateTenantID(c echo.Context) (string, bool) {
+	if host := c.Request().Host; len(host) > 0 {
+		if idx := strings.Index(host, "."); idx > 0 {
+			if sub := host[:idx]; sub != "" && sub != "www" {
+				return sub, true
+			}
+		}
+	}
+
+	if header := c.Request().Header.Get(TenantHeader); len(header) > 0 {
+		return header, true
+	}
+
+	return "", false
+}
+
+// validateTenantCandidate checks a caller-supplied candidate tenant (from
+// subdomain or header) against the caller's actual tenant membership. A
+// request with no candidate just resolves to the member tenant; a request
+// whose candidate disagrees with membership is rejected outright rather than
+// silently scoped to whichever tenant the caller asked for
+func validateTenantCandidate(memberTenantID, candidate string, hasCandidate bool) (string, error) {
+	if !hasCandidate {
+		return memberTenantID, nil
+	}
+	if candidate != memberTenantID {
+		return "", fmt.Errorf("Caller is not a member of tenant %q", candidate)
+	}
+	return memberTenantID, nil
+}
+
+// requireAdmin loads the session user and rejects the request unless they
+// are an admin, returning the loaded user for handlers that need it
+func (p *portalProxy) requireAdmin(c echo.Context) (*interfaces.ConnectedUser, error) {
+	userGUID, err := p.GetSessionStringValue(c, "user_id")
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "Could not find session user_id")
+	}
+
+	uaaUser, err := p.GetUAAUser(userGUID)
+	if err != nil || !uaaUser.Admin {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "This operation requires admin privileges")
+	}
+
+	return uaaUser, nil
+}
+
+// listTenants is an admin-only endpoint that lists the known tenants
+func (p *portalProxy) listTenants(c echo.Context) error {
+	if _, err := p.requireAdmin(c); err != nil {
+		return err
+	}
+
+	tenants, err := p.ListTenants()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not list tenants")
+	}
+
+	return c.JSON(http.StatusOK, tenants)
+}
+
+// createTenant is an admin-only endpoint that creates a tenant and mints a
+// tenant-scoped API token for it
+func (p *portalProxy) createTenant(c echo.Context) error {
+	if _, err := p.requireAdmin(c); err != nil {
+		return err
+	}
+
+	tenant := new(interfaces.Tenant)
+	if err := c.Bind(tenant); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Could not parse tenant")
+	}
+	if len(tenant.Name) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, errors.New("Tenant name is required").Error())
+	}
+
+	created, err := p.CreateTenant(tenant.Name)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not create tenant")
+	}
+
+	token, err := p.CreateTenantAPIToken(created.TenantID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not mint tenant API token")
+	}
+	created.APIToken = token
+
+	return c.JSON(http.StatusCreated, created)
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestValidateTenantCandidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		memberTenant string
+		candidate    string
+		hasCandidate bool
+		wantTenantID string
+		wantErr      bool
+	}{
+		{
+			name:         "no candidate resolves to membership",
+			memberTenant: "acme",
+			hasCandidate: false,
+			wantTenantID: "acme",
+		},
+		{
+			name:         "candidate matching membership is allowed",
+			memberTenant: "acme",
+			candidate:    "acme",
+			hasCandidate: true,
+			wantTenantID: "acme",
+		},
+		{
+			name:         "candidate for a different tenant is rejected",
+			memberTenant: "acme",
+			candidate:    "victim",
+			hasCandidate: true,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateTenantCandidate(tt.memberTenant, tt.candidate, tt.hasCandidate)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got tenant %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.wantTenantID {
+				t.Fatalf("expected tenant %q, got %q", tt.wantTenantID, got)
+			}
+		})
+	}
+}